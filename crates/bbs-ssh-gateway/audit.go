@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// auditLog emits structured JSON events (connect, auth-accept, auth-reject,
+// pty-open, disconnect) to stdout and, if BBS_AUDIT_LOG is set, also
+// appends them to a file.
+type auditLog struct {
+	logger *slog.Logger
+	closer func() error
+}
+
+func newAuditLog(path string) (*auditLog, error) {
+	w := io.Writer(os.Stdout)
+	closer := func() error { return nil }
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log %s: %w", path, err)
+		}
+		w = io.MultiWriter(os.Stdout, f)
+		closer = f.Close
+	}
+	return &auditLog{logger: slog.New(slog.NewJSONHandler(w, nil)), closer: closer}, nil
+}
+
+func (a *auditLog) Close() error { return a.closer() }
+
+func (a *auditLog) connect(remote string) {
+	a.logger.Info("connect", "remote", remote)
+}
+
+func (a *auditLog) authAccept(remote, keyType, fp string) {
+	a.logger.Info("auth-accept", "remote", remote, "key_type", keyType, "fp", shortFP(fp))
+}
+
+func (a *auditLog) authReject(remote, keyType, fp string) {
+	a.logger.Info("auth-reject", "remote", remote, "key_type", keyType, "fp", shortFP(fp))
+}
+
+func (a *auditLog) ptyOpen(remote string, cols, rows int) {
+	a.logger.Info("pty-open", "remote", remote, "cols", cols, "rows", rows)
+}
+
+func (a *auditLog) disconnect(remote string, duration time.Duration, resizeCount, bytesIn, bytesOut int64) {
+	a.logger.Info("disconnect", "remote", remote,
+		"duration_ms", duration.Milliseconds(),
+		"window_resize_count", resizeCount,
+		"bytes_in", bytesIn,
+		"bytes_out", bytesOut,
+	)
+}
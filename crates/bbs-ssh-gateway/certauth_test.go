@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadRoleMap(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "roles.conf")
+	content := "# comment\n\nalice admin,moderator\nbob room:general\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadRoleMap(path)
+	if err != nil {
+		t.Fatalf("loadRoleMap: %v", err)
+	}
+	want := map[string][]string{
+		"alice": {"admin", "moderator"},
+		"bob":   {"room:general"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("loadRoleMap = %#v, want %#v", got, want)
+	}
+}
+
+func TestLoadRoleMapMissingFile(t *testing.T) {
+	got, err := loadRoleMap(filepath.Join(t.TempDir(), "missing.conf"))
+	if err != nil {
+		t.Fatalf("loadRoleMap on missing file should not error, got: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected empty map for missing file, got %#v", got)
+	}
+}
+
+func TestLoadRoleMapMalformedLine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "roles.conf")
+	if err := os.WriteFile(path, []byte("alice\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadRoleMap(path); err == nil {
+		t.Fatal("expected error for malformed role map line")
+	}
+}
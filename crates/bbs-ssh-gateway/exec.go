@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+)
+
+// execMessage is the NDJSON shape emitted by `subscribe` and used for
+// `tail` output.
+type execMessage struct {
+	ID       int64     `json:"id"`
+	Room     string    `json:"room"`
+	PubkeyFP string    `json:"pubkey_fp"`
+	Body     string    `json:"body"`
+	PostedAt time.Time `json:"posted_at"`
+}
+
+// handleExec services a non-PTY session by dispatching s.Command() to a
+// scriptable subcommand, mirroring how git-over-ssh dispatches on
+// SSH_ORIGINAL_COMMAND. Reuses the session's pubkey auth for identity.
+func handleExec(s glssh.Session, db *sql.DB, defaultRoom string) {
+	args := s.Command()
+	if len(args) == 0 {
+		io.WriteString(s.Stderr(), "usage: post|tail|list-rooms|whoami|subscribe\n")
+		_ = s.Exit(1)
+		return
+	}
+
+	pk := s.PublicKey()
+	fp := "unknown"
+	if pk != nil {
+		fp = identityFingerprint(pk)
+	}
+	roles, _ := s.Context().Value(roleMapContextKey).([]string)
+
+	if db == nil {
+		fmt.Fprintln(s.Stderr(), "exec: DATABASE_URL is not configured")
+		_ = s.Exit(1)
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "whoami":
+		_, err = fmt.Fprintln(s, shortFP(fp))
+	case "list-rooms":
+		err = execListRooms(s, db)
+	case "post":
+		err = execPost(s, db, fp, roles, args[1:], defaultRoom)
+	case "tail":
+		err = execTail(s, db, roles, args[1:], defaultRoom)
+	case "subscribe":
+		err = execSubscribe(s, db, roles, args[1:], defaultRoom)
+	default:
+		fmt.Fprintf(s.Stderr(), "unknown command: %s\n", args[0])
+		_ = s.Exit(1)
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintf(s.Stderr(), "%v\n", err)
+		_ = s.Exit(1)
+		return
+	}
+	_ = s.Exit(0)
+}
+
+// hasRole reports whether roles contains role.
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// roomAllowed reports whether an identity with roles may post to or view
+// room. Identities with no "room:" scoped role, or with "admin"/"moderator",
+// may use any room; a "room:<name>" role restricts access to just that room.
+func roomAllowed(roles []string, room string) bool {
+	if hasRole(roles, "admin") || hasRole(roles, "moderator") {
+		return true
+	}
+	var scoped bool
+	for _, r := range roles {
+		if !strings.HasPrefix(r, "room:") {
+			continue
+		}
+		scoped = true
+		if strings.TrimPrefix(r, "room:") == room {
+			return true
+		}
+	}
+	return !scoped
+}
+
+func execListRooms(s glssh.Session, db *sql.DB) error {
+	rows, err := db.QueryContext(s.Context(), `SELECT DISTINCT room FROM messages ORDER BY room`)
+	if err != nil {
+		return fmt.Errorf("list-rooms: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var room string
+		if err := rows.Scan(&room); err != nil {
+			return err
+		}
+		fmt.Fprintln(s, room)
+	}
+	return rows.Err()
+}
+
+func execPost(s glssh.Session, db *sql.DB, fp string, roles []string, args []string, defaultRoom string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: post <room> <msg>")
+	}
+	room := args[0]
+	if hasRole(roles, "muted") {
+		return fmt.Errorf("post: muted, posting is not permitted")
+	}
+	if !roomAllowed(roles, room) {
+		return fmt.Errorf("post: not permitted to post in room %q", room)
+	}
+	body := strings.Join(args[1:], " ")
+	_, err := db.ExecContext(s.Context(),
+		`INSERT INTO messages (room, pubkey_fp, body, posted_at) VALUES ($1, $2, $3, $4)`,
+		room, fp, body, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+	return nil
+}
+
+func execTail(s glssh.Session, db *sql.DB, roles []string, args []string, defaultRoom string) error {
+	room := defaultRoom
+	n := 20
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-n":
+			if i+1 >= len(args) {
+				return fmt.Errorf("tail: -n requires a value")
+			}
+			v, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return fmt.Errorf("tail: invalid -n value: %w", err)
+			}
+			n = v
+			i++
+		default:
+			room = args[i]
+		}
+	}
+	if !roomAllowed(roles, room) {
+		return fmt.Errorf("tail: not permitted to view room %q", room)
+	}
+
+	rows, err := db.QueryContext(s.Context(),
+		`SELECT id, room, pubkey_fp, body, posted_at FROM messages WHERE room = $1 ORDER BY id DESC LIMIT $2`,
+		room, n,
+	)
+	if err != nil {
+		return fmt.Errorf("tail: %w", err)
+	}
+	defer rows.Close()
+
+	var msgs []execMessage
+	for rows.Next() {
+		var m execMessage
+		if err := rows.Scan(&m.ID, &m.Room, &m.PubkeyFP, &m.Body, &m.PostedAt); err != nil {
+			return err
+		}
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := len(msgs) - 1; i >= 0; i-- {
+		fmt.Fprintf(s, "[%s] %s: %s\n", msgs[i].PostedAt.Format(time.RFC3339), shortFP(msgs[i].PubkeyFP), msgs[i].Body)
+	}
+	return nil
+}
+
+// execSubscribe streams new messages in room as NDJSON until the client
+// disconnects, polling the DB for rows newer than the highest existing id.
+func execSubscribe(s glssh.Session, db *sql.DB, roles []string, args []string, defaultRoom string) error {
+	room := defaultRoom
+	if len(args) > 0 {
+		room = args[0]
+	}
+	if !roomAllowed(roles, room) {
+		return fmt.Errorf("subscribe: not permitted to view room %q", room)
+	}
+
+	var lastID int64
+	if err := db.QueryRowContext(s.Context(),
+		`SELECT COALESCE(MAX(id), 0) FROM messages WHERE room = $1`, room,
+	).Scan(&lastID); err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+
+	enc := json.NewEncoder(s)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.Context().Done():
+			return nil
+		case <-ticker.C:
+			rows, err := db.QueryContext(s.Context(),
+				`SELECT id, room, pubkey_fp, body, posted_at FROM messages WHERE room = $1 AND id > $2 ORDER BY id`,
+				room, lastID,
+			)
+			if err != nil {
+				return fmt.Errorf("subscribe: %w", err)
+			}
+			for rows.Next() {
+				var m execMessage
+				if err := rows.Scan(&m.ID, &m.Room, &m.PubkeyFP, &m.Body, &m.PostedAt); err != nil {
+					rows.Close()
+					return err
+				}
+				if err := enc.Encode(m); err != nil {
+					rows.Close()
+					return nil
+				}
+				lastID = m.ID
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestRoomAllowed(t *testing.T) {
+	cases := []struct {
+		name  string
+		roles []string
+		room  string
+		want  bool
+	}{
+		{"no roles", nil, "lobby", true},
+		{"unscoped role", []string{"muted"}, "lobby", true},
+		{"matching room scope", []string{"room:lobby"}, "lobby", true},
+		{"non-matching room scope", []string{"room:dev"}, "lobby", false},
+		{"admin bypasses room scope", []string{"admin", "room:dev"}, "lobby", true},
+		{"moderator bypasses room scope", []string{"moderator"}, "lobby", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := roomAllowed(c.roles, c.room); got != c.want {
+				t.Fatalf("roomAllowed(%v, %q) = %v, want %v", c.roles, c.room, got, c.want)
+			}
+		})
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	if !hasRole([]string{"muted", "room:lobby"}, "muted") {
+		t.Fatal("expected muted role to be found")
+	}
+	if hasRole([]string{"room:lobby"}, "muted") {
+		t.Fatal("did not expect muted role to be found")
+	}
+}
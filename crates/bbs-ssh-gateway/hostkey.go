@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// HostKeyProvider installs host key signer(s) onto server and returns the
+// primary signer (used for the startup banner). Select via
+// BBS_HOSTKEY_PROVIDER={file,agent,rotating}.
+type HostKeyProvider interface {
+	Start(server *glssh.Server) (gossh.Signer, error)
+}
+
+// newHostKeyProvider builds the HostKeyProvider selected by kind.
+func newHostKeyProvider(kind string) (HostKeyProvider, error) {
+	switch kind {
+	case "", "file":
+		return &fileHostKeyProvider{path: getenv("BBS_HOSTKEY_PATH", "/app/host-keys/hostkey.pem")}, nil
+	case "agent":
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, errors.New("SSH_AUTH_SOCK is not set")
+		}
+		return &agentHostKeyProvider{socket: socket}, nil
+	case "rotating":
+		interval, err := time.ParseDuration(getenv("BBS_HOSTKEY_ROTATE_INTERVAL", "168h"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid BBS_HOSTKEY_ROTATE_INTERVAL: %w", err)
+		}
+		return &rotatingHostKeyProvider{
+			interval:    interval,
+			publishPath: getenv("BBS_HOSTKEY_PUBLISH_PATH", "/app/host-keys/fingerprints.txt"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown BBS_HOSTKEY_PROVIDER %q", kind)
+	}
+}
+
+// fileHostKeyProvider is the original on-disk PKCS8 file behavior:
+// load the key if present, otherwise generate and persist one.
+type fileHostKeyProvider struct {
+	path string
+}
+
+func (p *fileHostKeyProvider) Start(server *glssh.Server) (gossh.Signer, error) {
+	signer := mustLoadOrCreateHostKey(p.path)
+	server.AddHostKey(signer)
+	return signer, nil
+}
+
+// agentHostKeyProvider sources the host key from a running ssh-agent, so
+// the private key material never leaves the agent process.
+type agentHostKeyProvider struct {
+	socket string
+}
+
+func (p *agentHostKeyProvider) Start(server *glssh.Server) (gossh.Signer, error) {
+	conn, err := net.Dial("unix", p.socket)
+	if err != nil {
+		return nil, fmt.Errorf("dialing ssh-agent at %s: %w", p.socket, err)
+	}
+	client := agent.NewClient(conn)
+	signers, err := client.Signers()
+	if err != nil {
+		return nil, fmt.Errorf("listing ssh-agent signers: %w", err)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("ssh-agent at %s has no keys loaded", p.socket)
+	}
+	for _, signer := range signers {
+		server.AddHostKey(signer)
+	}
+	return signers[0], nil
+}
+
+// rotatingHostKeyProvider keeps a current + previous ed25519 signer. Only
+// the current signer is presented to clients: server.AddHostKey replaces
+// the existing signer of the same key type, so each rotation takes the
+// previous key out of service. The fingerprints of the current and
+// previous keys are republished to publishPath on every rotation; the next
+// key is generated at rotation time, so its fingerprint is not published
+// before it goes live.
+type rotatingHostKeyProvider struct {
+	interval    time.Duration
+	publishPath string
+
+	mu       sync.Mutex
+	current  gossh.Signer
+	previous gossh.Signer
+}
+
+func (p *rotatingHostKeyProvider) Start(server *glssh.Server) (gossh.Signer, error) {
+	current, err := generateEd25519Signer()
+	if err != nil {
+		return nil, err
+	}
+	// p.mu also guards server.AddHostKey: glssh.Server.HostSigners is a
+	// plain slice that connection-handling goroutines read on every
+	// handshake once server.Serve is running, so our own AddHostKey calls
+	// from Start and rotateLoop must never be allowed to interleave with
+	// each other.
+	p.mu.Lock()
+	p.current = current
+	server.AddHostKey(current)
+	p.mu.Unlock()
+
+	if err := p.publish(); err != nil {
+		log.Printf("hostkey publish error (%s): %v", p.publishPath, err)
+	}
+	go p.rotateLoop(server)
+	return current, nil
+}
+
+func (p *rotatingHostKeyProvider) rotateLoop(server *glssh.Server) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		next, err := generateEd25519Signer()
+		if err != nil {
+			log.Printf("hostkey rotation error: %v", err)
+			continue
+		}
+
+		p.mu.Lock()
+		p.previous = p.current
+		p.current = next
+		// next has the same key type as p.previous, so AddHostKey replaces
+		// it: from here on only next is presented to clients.
+		server.AddHostKey(next)
+		p.mu.Unlock()
+
+		if err := p.publish(); err != nil {
+			log.Printf("hostkey publish error (%s): %v", p.publishPath, err)
+		}
+		log.Printf("hostkey rotated fp=%s", shortFP(gossh.FingerprintSHA256(next.PublicKey())))
+	}
+}
+
+func (p *rotatingHostKeyProvider) publish() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fps := []string{gossh.FingerprintSHA256(p.current.PublicKey())}
+	if p.previous != nil {
+		fps = append(fps, gossh.FingerprintSHA256(p.previous.PublicKey()))
+	}
+	return os.WriteFile(p.publishPath, []byte(strings.Join(fps, "\n")+"\n"), 0o644)
+}
+
+func generateEd25519Signer() (gossh.Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("hostkey gen error: %w", err)
+	}
+	return gossh.NewSignerFromKey(priv)
+}
+
+func mustLoadOrCreateHostKey(path string) gossh.Signer {
+	// Try to load PKCS8 PEM private key
+	b, err := os.ReadFile(path)
+	if err == nil {
+		signer, perr := parsePKCS8Signer(b)
+		if perr == nil {
+			return signer
+		}
+		log.Printf("hostkey parse error (%s), regenerating: %v", path, perr)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("hostkey read error (%s), regenerating: %v", path, err)
+	}
+
+	// Generate new ed25519 and store as PKCS8 PEM
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("hostkey gen error: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		log.Fatalf("hostkey marshal error: %v", err)
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.MkdirAll(dirOf(path), 0o700); err != nil {
+		log.Fatalf("hostkey mkdir error: %v", err)
+	}
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		log.Fatalf("hostkey write error: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		log.Fatalf("hostkey signer error: %v", err)
+	}
+	return signer
+}
+
+func parsePKCS8Signer(pemData []byte) (gossh.Signer, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("invalid pem")
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := k.(type) {
+	case ed25519.PrivateKey:
+		return gossh.NewSignerFromKey(key)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", k)
+	}
+}
+
+func dirOf(path string) string {
+	i := strings.LastIndexByte(path, '/')
+	if i < 0 {
+		return "."
+	}
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
@@ -1,23 +1,22 @@
 package main
 
 import (
-    "context"
-    "crypto/ed25519"
-    "crypto/rand"
-    "crypto/x509"
-    "encoding/pem"
-    "errors"
-    "fmt"
-    "io"
-    "log"
-    "net"
-    "os"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	pty "github.com/creack/pty"
 	glssh "github.com/gliderlabs/ssh"
+	_ "github.com/lib/pq"
 	gossh "golang.org/x/crypto/ssh"
 )
 
@@ -26,16 +25,49 @@ func main() {
 	clientPath := getenv("BBS_CLIENT_PATH", "/app/bbs-tui")
 	defaultRoom := getenv("BBS_DEFAULT_ROOM", "lobby")
 	databaseURL := os.Getenv("DATABASE_URL")
+	uploadRoot := getenv("BBS_UPLOAD_ROOT", "/app/attachments")
+	attachmentQuota := mustParseInt64(getenv("BBS_ATTACHMENT_QUOTA_BYTES", "104857600"))
+	trustProxyCIDRs := splitNonEmpty(os.Getenv("BBS_TRUST_PROXY_CIDRS"), ",")
 
-    hostKeyPath := getenv("BBS_HOSTKEY_PATH", "/app/host-keys/hostkey.pem")
-    signer := mustLoadOrCreateHostKey(hostKeyPath)
+	hostKeyProvider, err := newHostKeyProvider(getenv("BBS_HOSTKEY_PROVIDER", "file"))
+	if err != nil {
+		log.Fatalf("hostkey provider error: %v", err)
+	}
+
+	audit, err := newAuditLog(os.Getenv("BBS_AUDIT_LOG"))
+	if err != nil {
+		log.Fatalf("audit log setup error: %v", err)
+	}
+	defer audit.Close()
+
+	var certAuth *certAuthenticator
+	if caPath := os.Getenv("BBS_TRUSTED_USER_CA"); caPath != "" {
+		a, err := newCertAuthenticator(caPath, getenv("BBS_ROLE_MAP_PATH", "/app/roles.conf"))
+		if err != nil {
+			log.Fatalf("cert authenticator setup error: %v", err)
+		}
+		certAuth = a
+	}
+
+	var attachmentsDB *sql.DB
+	if databaseURL != "" {
+		db, err := sql.Open("postgres", databaseURL)
+		if err != nil {
+			log.Fatalf("attachments db open error: %v", err)
+		}
+		attachmentsDB = db
+	}
+
+	subsystemHandlers := map[string]glssh.SubsystemHandler{
+		"sftp": sftpSubsystem(uploadRoot, attachmentsDB, attachmentQuota),
+	}
+
+	presence := newPresenceRegistry()
 
 	glssh.Handle(func(s glssh.Session) {
-		// Require PTY
 		ptyReq, winCh, ok := s.Pty()
 		if !ok {
-			io.WriteString(s, "A PTY is required.\n")
-			_ = s.Exit(1)
+			handleExec(s, attachmentsDB, defaultRoom)
 			return
 		}
 
@@ -43,10 +75,13 @@ func main() {
 		fp := "unknown"
 		ktype := "unknown"
 		if pk != nil {
-			fp = gossh.FingerprintSHA256(pk)
+			fp = identityFingerprint(pk)
 			ktype = mapKeyType(pk.Type())
 		}
-		log.Printf("connect remote=%s key=%s fp=%s", remoteAddr(s), ktype, shortFP(fp))
+		presence.join(fp)
+		defer presence.leave(fp)
+		started := time.Now()
+		audit.connect(remoteAddr(s))
 
 		// Prepare command
 		cmd := exec.CommandContext(s.Context(), clientPath)
@@ -57,6 +92,9 @@ func main() {
 			"DATABASE_URL="+databaseURL,
 			"BBS_DEFAULT_ROOM="+defaultRoom,
 		)
+		if roles, ok := s.Context().Value(roleMapContextKey).([]string); ok && len(roles) > 0 {
+			cmd.Env = append(cmd.Env, "BBS_ROLES="+strings.Join(roles, ","))
+		}
 
 		// Allocate PTY for the child
 		f, err := pty.Start(cmd)
@@ -69,20 +107,38 @@ func main() {
 
 		// Set initial window size
 		_ = pty.Setsize(f, &pty.Winsize{Cols: uint16(ptyReq.Window.Width), Rows: uint16(ptyReq.Window.Height)})
+		audit.ptyOpen(remoteAddr(s), ptyReq.Window.Width, ptyReq.Window.Height)
 
 		// Propagate future window changes
+		var resizeCount int64
 		go func() {
 			for w := range winCh {
 				_ = pty.Setsize(f, &pty.Winsize{Cols: uint16(w.Width), Rows: uint16(w.Height)})
+				atomic.AddInt64(&resizeCount, 1)
 			}
 		}()
 
-		// Pipe data
-		go func() { _, _ = io.Copy(f, s) }()
-		_, _ = io.Copy(s, f)
+		// Pipe data, counting bytes in each direction
+		var bytesIn int64
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := s.Read(buf)
+				if n > 0 {
+					if _, werr := f.Write(buf[:n]); werr != nil {
+						return
+					}
+					atomic.AddInt64(&bytesIn, int64(n))
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+		bytesOut, _ := io.Copy(s, f)
 
 		_ = cmd.Wait()
-		log.Printf("disconnect remote=%s", remoteAddr(s))
+		audit.disconnect(remoteAddr(s), time.Since(started), atomic.LoadInt64(&resizeCount), atomic.LoadInt64(&bytesIn), bytesOut)
 	})
 
 	// Public key auth: allow modern algorithms only
@@ -92,6 +148,22 @@ func main() {
 		Version:     "SSH-2.0-bbs-ssh-gateway",
 		IdleTimeout: 2 * time.Hour,
 		PublicKeyHandler: func(ctx glssh.Context, key glssh.PublicKey) bool {
+			remote := normalizeAddr(ctx.RemoteAddr())
+			fp := identityFingerprint(key)
+
+			if certAuth != nil {
+				if roles, ok := certAuth.authenticate(ctx, key); ok {
+					ctx.SetValue(roleMapContextKey, roles)
+					ctx.SetValue(pubkeyFPContextKey, fp)
+					audit.authAccept(remote, "cert:"+mapKeyType(key.Type()), fp)
+					return true
+				}
+				if _, isCert := key.(*gossh.Certificate); isCert {
+					audit.authReject(remote, "cert:"+mapKeyType(key.Type()), fp)
+					return false
+				}
+			}
+
 			t := key.Type()
 			allowed := map[string]bool{
 				"ssh-ed25519":                true,
@@ -102,74 +174,55 @@ func main() {
 				"sk-ssh-ed25519@openssh.com": true,
 			}
 			if !allowed[t] {
+				audit.authReject(remote, mapKeyType(t), fp)
 				return false
 			}
+			ctx.SetValue(pubkeyFPContextKey, fp)
+			audit.authAccept(remote, mapKeyType(t), fp)
 			return true
 		},
-		PasswordHandler:               func(ctx glssh.Context, pass string) bool { return false },
-		LocalPortForwardingCallback:   func(ctx glssh.Context, dhost string, dport uint32) bool { return false },
+		PasswordHandler:   func(ctx glssh.Context, pass string) bool { return false },
+		SubsystemHandlers: subsystemHandlers,
+		ChannelHandlers: map[string]glssh.ChannelHandler{
+			"session":      glssh.DefaultSessionHandler,
+			"direct-tcpip": newAPITunnelHandler(attachmentsDB, presence),
+		},
+		LocalPortForwardingCallback: func(ctx glssh.Context, dhost string, dport uint32) bool {
+			return dhost == apiSentinelHost
+		},
 		ReversePortForwardingCallback: func(ctx glssh.Context, host string, port uint32) bool { return false },
 	}
-	server.AddHostKey(signer)
+	signer, err := hostKeyProvider.Start(server)
+	if err != nil {
+		log.Fatalf("hostkey provider start error: %v", err)
+	}
 
-    log.Printf("hostkey fp=%s", shortFP(gossh.FingerprintSHA256(signer.PublicKey())))
-    log.Printf("listening on %s; client=%s room=%s", addr, clientPath, defaultRoom)
-    if err := server.ListenAndServe(); err != nil {
-        log.Fatalf("ssh server error: %v", err)
-    }
-}
+	log.Printf("hostkey fp=%s", shortFP(gossh.FingerprintSHA256(signer.PublicKey())))
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("listen error: %v", err)
+	}
+	if len(trustProxyCIDRs) > 0 {
+		ln, err = newProxyListener(ln, trustProxyCIDRs)
+		if err != nil {
+			log.Fatalf("proxy protocol setup error: %v", err)
+		}
+		log.Printf("PROXY protocol v2 accepted from %s", strings.Join(trustProxyCIDRs, ","))
+	}
 
-func mustLoadOrCreateHostKey(path string) gossh.Signer {
-    // Try to load PKCS8 PEM private key
-    b, err := os.ReadFile(path)
-    if err == nil {
-        signer, perr := parsePKCS8Signer(b)
-        if perr == nil {
-            return signer
-        }
-        log.Printf("hostkey parse error (%s), regenerating: %v", path, perr)
-    } else if !errors.Is(err, os.ErrNotExist) {
-        log.Printf("hostkey read error (%s), regenerating: %v", path, err)
-    }
-
-    // Generate new ed25519 and store as PKCS8 PEM
-    _, priv, err := ed25519.GenerateKey(rand.Reader)
-    if err != nil {
-        log.Fatalf("hostkey gen error: %v", err)
-    }
-    der, err := x509.MarshalPKCS8PrivateKey(priv)
-    if err != nil {
-        log.Fatalf("hostkey marshal error: %v", err)
-    }
-    pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
-    if err := os.MkdirAll(dirOf(path), 0o700); err != nil {
-        log.Fatalf("hostkey mkdir error: %v", err)
-    }
-    if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
-        log.Fatalf("hostkey write error: %v", err)
-    }
-    signer, err := gossh.NewSignerFromKey(priv)
-    if err != nil {
-        log.Fatalf("hostkey signer error: %v", err)
-    }
-    return signer
+	log.Printf("listening on %s; client=%s room=%s", addr, clientPath, defaultRoom)
+	if err := server.Serve(ln); err != nil {
+		log.Fatalf("ssh server error: %v", err)
+	}
 }
 
-func parsePKCS8Signer(pemData []byte) (gossh.Signer, error) {
-    block, _ := pem.Decode(pemData)
-    if block == nil || block.Type != "PRIVATE KEY" {
-        return nil, fmt.Errorf("invalid pem")
-    }
-    k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-    if err != nil {
-        return nil, err
-    }
-    switch key := k.(type) {
-    case ed25519.PrivateKey:
-        return gossh.NewSignerFromKey(key)
-    default:
-        return nil, fmt.Errorf("unsupported key type: %T", k)
-    }
+func mustParseInt64(s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid integer env value %q: %v", s, err)
+	}
+	return n
 }
 
 func getenv(k, def string) string {
@@ -199,14 +252,17 @@ func mapKeyType(t string) string {
 }
 
 func remoteAddr(s glssh.Session) string {
-	ra := s.RemoteAddr()
-	if ra == nil {
+	return normalizeAddr(s.RemoteAddr())
+}
+
+// normalizeAddr renders addr as host:port, stripping any IPv6 zone.
+func normalizeAddr(addr net.Addr) string {
+	if addr == nil {
 		return ""
 	}
-	// normalize to host:port, without zone
-	host, port, err := net.SplitHostPort(ra.String())
+	host, port, err := net.SplitHostPort(addr.String())
 	if err != nil {
-		return ra.String()
+		return addr.String()
 	}
 	if i := strings.IndexByte(host, '%'); i >= 0 {
 		host = host[:i]
@@ -214,6 +270,21 @@ func remoteAddr(s glssh.Session) string {
 	return net.JoinHostPort(host, port)
 }
 
+// splitNonEmpty splits s on sep, dropping empty fields; it returns nil for
+// an empty input so callers can treat "unset" and "empty list" the same.
+func splitNonEmpty(s, sep string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, part := range strings.Split(s, sep) {
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
 // Ensure the command is terminated if the session context is cancelled.
 func killOnDone(ctx context.Context, cmd *exec.Cmd) {
 	go func() {
@@ -223,22 +294,11 @@ func killOnDone(ctx context.Context, cmd *exec.Cmd) {
 }
 
 func shortFP(fp string) string {
-    if strings.HasPrefix(fp, "SHA256:") {
-        fp = strings.TrimPrefix(fp, "SHA256:")
-    }
-    if len(fp) > 8 {
-        return fp[:8]
-    }
-    return fp
-}
-
-func dirOf(path string) string {
-    i := strings.LastIndexByte(path, '/')
-    if i < 0 {
-        return "."
-    }
-    if i == 0 {
-        return "/"
-    }
-    return path[:i]
+	if strings.HasPrefix(fp, "SHA256:") {
+		fp = strings.TrimPrefix(fp, "SHA256:")
+	}
+	if len(fp) > 8 {
+		return fp[:8]
+	}
+	return fp
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+)
+
+// proxyV2Sig is the 12-byte signature that opens every PROXY protocol v2
+// header.
+var proxyV2Sig = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// proxyListener wraps a net.Listener, decoding a leading HAProxy PROXY
+// protocol v2 header on connections from trusted upstreams so
+// conn.RemoteAddr() reflects the real client address instead of the
+// upstream's. Connections from untrusted sources are passed through
+// unmodified.
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+// newProxyListener builds a proxyListener that only honors PROXY headers
+// from sources in trustedCIDRs (BBS_TRUST_PROXY_CIDRS).
+func newProxyListener(inner net.Listener, trustedCIDRs []string) (*proxyListener, error) {
+	nets := make([]*net.IPNet, 0, len(trustedCIDRs))
+	for _, c := range trustedCIDRs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("parsing BBS_TRUST_PROXY_CIDRS entry %q: %w", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return &proxyListener{Listener: inner, trusted: nets}, nil
+}
+
+// Accept blocks until the next usable connection. A connection from a
+// trusted source with a malformed PROXY header is logged and dropped
+// rather than returned as an error: Accept's caller (glssh.Server.Serve)
+// treats any error here as fatal and tears down the whole listener, so one
+// bad probe must not be allowed to take down every other session.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !l.isTrustedSource(conn) {
+			return conn, nil
+		}
+		wrapped, err := wrapProxyConn(conn)
+		if err != nil {
+			log.Printf("proxy protocol: dropping connection from %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		return wrapped, nil
+	}
+}
+
+func (l *proxyListener) isTrustedSource(conn net.Conn) bool {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn is a net.Conn whose RemoteAddr has been overridden by a parsed
+// PROXY protocol v2 header. Reads are served from a buffered reader since
+// the header bytes were already consumed from the underlying conn.
+type proxyConn struct {
+	net.Conn
+	br     *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) { return c.br.Read(p) }
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// wrapProxyConn peeks for a PROXY v2 signature and, if present, parses the
+// header and strips it from the stream. Connections without the signature
+// are returned unmodified (aside from buffering).
+func wrapProxyConn(conn net.Conn) (net.Conn, error) {
+	br := bufio.NewReaderSize(conn, 256)
+	sig, err := br.Peek(len(proxyV2Sig))
+	if err != nil || !bytes.Equal(sig, proxyV2Sig) {
+		return &proxyConn{Conn: conn, br: br}, nil
+	}
+
+	hdr := make([]byte, len(proxyV2Sig)+4)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, fmt.Errorf("proxy protocol: reading header: %w", err)
+	}
+	verCmd := hdr[12]
+	famProto := hdr[13]
+	addrLen := int(binary.BigEndian.Uint16(hdr[14:16]))
+
+	addrData := make([]byte, addrLen)
+	if _, err := io.ReadFull(br, addrData); err != nil {
+		return nil, fmt.Errorf("proxy protocol: reading address block: %w", err)
+	}
+
+	if version := verCmd >> 4; version != 2 {
+		return nil, fmt.Errorf("proxy protocol: unsupported version %d", version)
+	}
+
+	pc := &proxyConn{Conn: conn, br: br}
+	if command := verCmd & 0x0F; command == 0x1 { // PROXY (not LOCAL health check)
+		if remote, err := parseProxyAddr(famProto, addrData); err == nil {
+			pc.remote = remote
+		}
+	}
+	return pc, nil
+}
+
+func parseProxyAddr(famProto byte, data []byte) (net.Addr, error) {
+	switch family := famProto >> 4; family {
+	case 0x1: // AF_INET
+		if len(data) < 12 {
+			return nil, fmt.Errorf("proxy protocol: short ipv4 address block")
+		}
+		srcPort := binary.BigEndian.Uint16(data[8:10])
+		return &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(srcPort)}, nil
+	case 0x2: // AF_INET6
+		if len(data) < 36 {
+			return nil, fmt.Errorf("proxy protocol: short ipv6 address block")
+		}
+		srcPort := binary.BigEndian.Uint16(data[32:34])
+		return &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(srcPort)}, nil
+	default:
+		return nil, fmt.Errorf("proxy protocol: unsupported address family %d", family)
+	}
+}
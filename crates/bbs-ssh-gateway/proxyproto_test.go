@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// proxyV2Header builds a minimal PROXY v2 TCP4 header carrying srcIP:srcPort
+// as the proxied client address, for use as test fixture data.
+func proxyV2Header(srcIP net.IP, srcPort uint16) []byte {
+	addr := make([]byte, 12)
+	copy(addr[0:4], srcIP.To4())
+	copy(addr[4:8], net.IPv4(10, 0, 0, 1).To4())
+	binary.BigEndian.PutUint16(addr[8:10], srcPort)
+	binary.BigEndian.PutUint16(addr[10:12], 22)
+
+	hdr := make([]byte, 0, len(proxyV2Sig)+4+len(addr))
+	hdr = append(hdr, proxyV2Sig...)
+	hdr = append(hdr, 0x21) // version 2, command PROXY
+	hdr = append(hdr, 0x11) // AF_INET, STREAM
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(len(addr)))
+	hdr = append(hdr, lenBuf...)
+	hdr = append(hdr, addr...)
+	return hdr
+}
+
+func TestWrapProxyConnValidHeader(t *testing.T) {
+	hdr := proxyV2Header(net.IPv4(203, 0, 113, 7), 54321)
+	conn := &fakeConn{data: append(hdr, []byte("payload")...)}
+
+	wrapped, err := wrapProxyConn(conn)
+	if err != nil {
+		t.Fatalf("wrapProxyConn: %v", err)
+	}
+
+	tcpAddr, ok := wrapped.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		t.Fatalf("RemoteAddr = %#v, want *net.TCPAddr", wrapped.RemoteAddr())
+	}
+	if !tcpAddr.IP.Equal(net.IPv4(203, 0, 113, 7)) || tcpAddr.Port != 54321 {
+		t.Fatalf("RemoteAddr = %s, want 203.0.113.7:54321", tcpAddr)
+	}
+
+	buf := make([]byte, 7)
+	if _, err := wrapped.Read(buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(buf) != "payload" {
+		t.Fatalf("Read = %q, want %q", buf, "payload")
+	}
+}
+
+func TestWrapProxyConnNoSignature(t *testing.T) {
+	conn := &fakeConn{data: []byte("SSH-2.0-OpenSSH_9.0\r\n")}
+	wrapped, err := wrapProxyConn(conn)
+	if err != nil {
+		t.Fatalf("wrapProxyConn: %v", err)
+	}
+	if wrapped.RemoteAddr().String() != conn.RemoteAddr().String() {
+		t.Fatalf("expected passthrough RemoteAddr for non-PROXY connection, got %s want %s",
+			wrapped.RemoteAddr(), conn.RemoteAddr())
+	}
+}
+
+func TestWrapProxyConnTruncatedHeader(t *testing.T) {
+	hdr := proxyV2Header(net.IPv4(203, 0, 113, 7), 54321)
+	conn := &fakeConn{data: hdr[:len(hdr)-4]}
+
+	if _, err := wrapProxyConn(conn); err == nil {
+		t.Fatal("expected error for truncated PROXY header")
+	}
+}
+
+func TestWrapProxyConnUnsupportedVersion(t *testing.T) {
+	hdr := proxyV2Header(net.IPv4(203, 0, 113, 7), 54321)
+	hdr[12] = 0x11 // version 1, command PROXY
+	conn := &fakeConn{data: hdr}
+
+	if _, err := wrapProxyConn(conn); err == nil {
+		t.Fatal("expected error for unsupported PROXY version")
+	}
+}
+
+func TestParseProxyAddrShortBlock(t *testing.T) {
+	if _, err := parseProxyAddr(0x11, []byte{1, 2, 3}); err == nil {
+		t.Fatal("expected error for short ipv4 address block")
+	}
+}
+
+// fakeConn is a minimal net.Conn backed by an in-memory byte slice, enough
+// to exercise wrapProxyConn's peek/read-full logic without a real socket.
+type fakeConn struct {
+	net.Conn
+	data []byte
+	pos  int
+}
+
+func (c *fakeConn) Read(p []byte) (int, error) {
+	if c.pos >= len(c.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, c.data[c.pos:])
+	c.pos += n
+	return n, nil
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 9999}
+}
+
+func (c *fakeConn) Close() error { return nil }
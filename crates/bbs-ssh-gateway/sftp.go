@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+)
+
+// sftpSubsystem wires a per-connection SFTP subsystem onto the server,
+// scoping each user to a virtual root keyed by their pubkey fingerprint.
+func sftpSubsystem(uploadRoot string, db *sql.DB, defaultQuota int64) glssh.SubsystemHandler {
+	return func(s glssh.Session) {
+		pk := s.PublicKey()
+		if pk == nil {
+			io.WriteString(s, "public key auth required for sftp\n")
+			_ = s.Exit(1)
+			return
+		}
+		fp := identityFingerprint(pk)
+
+		fsys := &bbsVirtualFS{
+			root:    path.Join(uploadRoot, shortFP(fp)),
+			fp:      fp,
+			db:      db,
+			quota:   userQuota(s.Context(), db, fp, defaultQuota),
+			session: s,
+		}
+		if err := os.MkdirAll(path.Join(fsys.root, "uploads"), 0o700); err != nil {
+			fmt.Fprintf(s, "sftp: failed to prepare home: %v\n", err)
+			_ = s.Exit(1)
+			return
+		}
+
+		handlers := sftp.Handlers{
+			FileGet:  fsys,
+			FilePut:  fsys,
+			FileCmd:  fsys,
+			FileList: fsys,
+		}
+		srv := sftp.NewRequestServer(s, handlers)
+		defer srv.Close()
+		if err := srv.Serve(); err != nil && err != io.EOF {
+			log.Printf("sftp remote=%s fp=%s error: %v", remoteAddr(s), shortFP(fp), err)
+		}
+	}
+}
+
+// userQuota looks up fp's per-user quota from the attachment_quotas table,
+// falling back to defaultQuota when db is unset or the user has no row.
+func userQuota(ctx context.Context, db *sql.DB, fp string, defaultQuota int64) int64 {
+	if db == nil {
+		return defaultQuota
+	}
+	var quota int64
+	err := db.QueryRowContext(ctx, `SELECT quota_bytes FROM attachment_quotas WHERE pubkey_fp = $1`, fp).Scan(&quota)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("sftp: failed to look up quota fp=%s: %v", shortFP(fp), err)
+		}
+		return defaultQuota
+	}
+	return quota
+}
+
+// bbsVirtualFS implements sftp.FileReader/FileWriter/FileCmder/FileLister
+// over a per-user directory, presenting:
+//
+//	/rooms/<room>/history.jsonl  - read-only archived transcript
+//	/uploads/<name>              - writable attachment staging area
+type bbsVirtualFS struct {
+	root    string
+	fp      string
+	db      *sql.DB
+	quota   int64
+	session glssh.Session
+
+	mu       sync.Mutex
+	uploaded int64 // on-disk usage at the last Filewrite plus bytes written since, checked against quota
+}
+
+func (v *bbsVirtualFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	if !strings.HasPrefix(r.Filepath, "/rooms/") || !strings.HasSuffix(r.Filepath, "/history.jsonl") {
+		return nil, os.ErrPermission
+	}
+	room := strings.TrimSuffix(strings.TrimPrefix(r.Filepath, "/rooms/"), "/history.jsonl")
+	if room == "" || strings.Contains(room, "/") {
+		return nil, os.ErrInvalid
+	}
+	return v.roomHistory(room)
+}
+
+// roomHistory renders room's full transcript as NDJSON, one execMessage per
+// line, generated from the messages table rather than a file on disk.
+func (v *bbsVirtualFS) roomHistory(room string) (io.ReaderAt, error) {
+	if v.db == nil {
+		return nil, fmt.Errorf("sftp: DATABASE_URL is not configured")
+	}
+	rows, err := v.db.Query(
+		`SELECT id, room, pubkey_fp, body, posted_at FROM messages WHERE room = $1 ORDER BY id`, room,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: loading history for room %q: %w", room, err)
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for rows.Next() {
+		var m execMessage
+		if err := rows.Scan(&m.ID, &m.Room, &m.PubkeyFP, &m.Body, &m.PostedAt); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(m); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), nil
+}
+
+func (v *bbsVirtualFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if !strings.HasPrefix(r.Filepath, "/uploads/") {
+		return nil, os.ErrPermission
+	}
+	name := strings.TrimPrefix(r.Filepath, "/uploads/")
+	if name == "" || strings.Contains(name, "/") {
+		return nil, os.ErrInvalid
+	}
+
+	used, err := v.usedQuota()
+	if err != nil {
+		return nil, err
+	}
+	if used >= v.quota {
+		return nil, fmt.Errorf("quota exceeded for fp=%s (%d/%d bytes)", shortFP(v.fp), used, v.quota)
+	}
+
+	v.mu.Lock()
+	v.uploaded = used
+	v.mu.Unlock()
+
+	f, err := os.OpenFile(path.Join(v.root, "uploads", name), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaWriter{f: f, fsys: v, name: name}, nil
+}
+
+func (v *bbsVirtualFS) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Remove":
+		if !strings.HasPrefix(r.Filepath, "/uploads/") {
+			return os.ErrPermission
+		}
+		return os.Remove(path.Join(v.root, "uploads", strings.TrimPrefix(r.Filepath, "/uploads/")))
+	case "Mkdir", "Rename", "Symlink", "Setstat":
+		return sftp.ErrSshFxOpUnsupported
+	default:
+		return sftp.ErrSshFxOpUnsupported
+	}
+}
+
+func (v *bbsVirtualFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		return v.list(r.Filepath)
+	case "Stat":
+		return v.stat(r.Filepath)
+	default:
+		return nil, sftp.ErrSshFxOpUnsupported
+	}
+}
+
+func (v *bbsVirtualFS) list(p string) (sftp.ListerAt, error) {
+	switch {
+	case p == "/":
+		return listerFromInfos([]os.FileInfo{dirInfo("rooms"), dirInfo("uploads")}), nil
+	case p == "/uploads":
+		entries, err := os.ReadDir(path.Join(v.root, "uploads"))
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]os.FileInfo, 0, len(entries))
+		for _, e := range entries {
+			if info, err := e.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return listerFromInfos(infos), nil
+	case p == "/rooms":
+		entries, err := os.ReadDir(path.Join(v.root, "rooms"))
+		if err != nil {
+			return listerFromInfos(nil), nil
+		}
+		infos := make([]os.FileInfo, 0, len(entries))
+		for _, e := range entries {
+			infos = append(infos, dirInfo(e.Name()))
+		}
+		return listerFromInfos(infos), nil
+	case strings.HasPrefix(p, "/rooms/"):
+		return listerFromInfos([]os.FileInfo{fileInfo("history.jsonl")}), nil
+	default:
+		return nil, os.ErrNotExist
+	}
+}
+
+func (v *bbsVirtualFS) stat(p string) (sftp.ListerAt, error) {
+	if p == "/" || p == "/uploads" || p == "/rooms" {
+		return listerFromInfos([]os.FileInfo{dirInfo(path.Base(p))}), nil
+	}
+	if strings.HasPrefix(p, "/uploads/") {
+		info, err := os.Stat(path.Join(v.root, "uploads", strings.TrimPrefix(p, "/uploads/")))
+		if err != nil {
+			return nil, err
+		}
+		return listerFromInfos([]os.FileInfo{info}), nil
+	}
+	if strings.HasSuffix(p, "/history.jsonl") {
+		return listerFromInfos([]os.FileInfo{fileInfo("history.jsonl")}), nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (v *bbsVirtualFS) usedQuota() (int64, error) {
+	entries, err := os.ReadDir(path.Join(v.root, "uploads"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
+
+// registerAttachment records an uploaded file so the TUI's `/attach` command
+// can link it to the user's next posted message.
+func (v *bbsVirtualFS) registerAttachment(name string, size int64) {
+	if v.db == nil {
+		return
+	}
+	_, err := v.db.Exec(
+		`INSERT INTO attachments (pubkey_fp, filename, size_bytes, uploaded_at) VALUES ($1, $2, $3, $4)`,
+		v.fp, name, size, time.Now().UTC(),
+	)
+	if err != nil {
+		log.Printf("sftp: failed to register attachment fp=%s name=%s: %v", shortFP(v.fp), name, err)
+	}
+}
+
+// quotaWriter tracks bytes written to enforce the per-user quota and
+// registers the attachment once the upload completes.
+type quotaWriter struct {
+	f    *os.File
+	fsys *bbsVirtualFS
+	name string
+}
+
+func (w *quotaWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.fsys.mu.Lock()
+	w.fsys.uploaded += int64(len(p))
+	over := w.fsys.uploaded > w.fsys.quota
+	w.fsys.mu.Unlock()
+	if over {
+		return 0, fmt.Errorf("quota exceeded for fp=%s", shortFP(w.fsys.fp))
+	}
+	return w.f.WriteAt(p, off)
+}
+
+func (w *quotaWriter) Close() error {
+	err := w.f.Close()
+	if info, statErr := os.Stat(w.f.Name()); statErr == nil {
+		w.fsys.registerAttachment(w.name, info.Size())
+	}
+	return err
+}
+
+func listerFromInfos(infos []os.FileInfo) sftp.ListerAt {
+	return sftpListerAt(infos)
+}
+
+type sftpListerAt []os.FileInfo
+
+func (l sftpListerAt) ListAt(out []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(out, l[offset:])
+	if n < len(out) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func dirInfo(name string) os.FileInfo  { return virtualFileInfo{name: name, dir: true} }
+func fileInfo(name string) os.FileInfo { return virtualFileInfo{name: name} }
+
+type virtualFileInfo struct {
+	name string
+	dir  bool
+}
+
+func (v virtualFileInfo) Name() string { return v.name }
+func (v virtualFileInfo) Size() int64  { return 0 }
+func (v virtualFileInfo) Mode() fs.FileMode {
+	if v.dir {
+		return os.ModeDir | 0o700
+	}
+	return 0o400
+}
+func (v virtualFileInfo) ModTime() time.Time { return time.Time{} }
+func (v virtualFileInfo) IsDir() bool        { return v.dir }
+func (v virtualFileInfo) Sys() interface{}   { return nil }
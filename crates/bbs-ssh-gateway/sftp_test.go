@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestQuotaWriterEnforcesLimit(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.CreateTemp(dir, "upload-*.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fsys := &bbsVirtualFS{fp: "SHA256:test", quota: 10, uploaded: 8}
+	w := &quotaWriter{f: f, fsys: fsys, name: "upload.bin"}
+
+	if _, err := w.WriteAt([]byte{1, 2}, 0); err != nil {
+		t.Fatalf("WriteAt within quota: %v", err)
+	}
+	if fsys.uploaded != 10 {
+		t.Fatalf("uploaded = %d, want 10", fsys.uploaded)
+	}
+
+	if _, err := w.WriteAt([]byte{3}, 2); err == nil {
+		t.Fatal("expected quota exceeded error")
+	}
+}
+
+func TestUserQuotaFallsBackWithoutDB(t *testing.T) {
+	if got := userQuota(nil, nil, "SHA256:test", 4096); got != 4096 {
+		t.Fatalf("userQuota = %d, want default 4096", got)
+	}
+}
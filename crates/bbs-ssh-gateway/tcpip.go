@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// apiSentinelHost is the pseudo-hostname clients forward to
+// (`ssh -N -L 8080:bbs/api bbs.host`) to reach the in-process HTTP API
+// instead of a real network destination.
+const apiSentinelHost = "bbs/api"
+
+// pubkeyFPContextKey stores the authenticated session's key fingerprint so
+// channel handlers other than the interactive session (e.g. the tcpip
+// tunnel) can identify the caller.
+const pubkeyFPContextKey = "bbs_pubkey_fp"
+
+type forwardChannelData struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// newAPITunnelHandler builds a ChannelHandler for "direct-tcpip" that,
+// instead of forwarding to the network, dispatches to an in-process HTTP
+// handler exposing rooms/messages/presence as JSON, backed by db and the
+// shared presence registry. Only the sentinel destination apiSentinelHost
+// is accepted; this handler rejects every other destination itself and
+// does not consult LocalPortForwardingCallback, so no real network
+// forwarding takes place.
+func newAPITunnelHandler(db *sql.DB, presence *presenceRegistry) glssh.ChannelHandler {
+	return func(srv *glssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx glssh.Context) {
+		var data forwardChannelData
+		if err := gossh.Unmarshal(newChan.ExtraData(), &data); err != nil {
+			_ = newChan.Reject(gossh.ConnectionFailed, "malformed forward request")
+			return
+		}
+		if data.DestAddr != apiSentinelHost {
+			_ = newChan.Reject(gossh.Prohibited, "only "+apiSentinelHost+" may be forwarded")
+			return
+		}
+
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		defer ch.Close()
+
+		fp, _ := ctx.Value(pubkeyFPContextKey).(string)
+		roles, _ := ctx.Value(roleMapContextKey).([]string)
+		log.Printf("tcpip-tunnel remote=%s fp=%s dest=%s", remoteAddr2(conn), shortFP(fp), apiSentinelHost)
+
+		reqsDone := make(chan struct{})
+		go func() {
+			gossh.DiscardRequests(reqs)
+			close(reqsDone)
+		}()
+
+		conn2 := &channelConn{Channel: ch, local: tunnelAddr{}}
+		l := &singleConnListener{conn: conn2, done: make(chan struct{})}
+		go func() {
+			<-reqsDone
+			l.Close()
+		}()
+
+		httpSrv := &http.Server{Handler: bbsAPIHandler(fp, roles, db, presence)}
+		_ = httpSrv.Serve(l)
+	}
+}
+
+// bbsAPIHandler builds the JSON API exposed over the tunnel, scoped to the
+// caller identified by pubkey fingerprint and restricted to the rooms their
+// roles permit (the same roomAllowed rule exec.go enforces for post/tail/
+// subscribe — this tunnel bypasses the TUI just like exec mode does, so it
+// needs the identical check).
+func bbsAPIHandler(fp string, roles []string, db *sql.DB, presence *presenceRegistry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rooms", func(w http.ResponseWriter, r *http.Request) {
+		rooms, err := listRooms(r.Context(), db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		allowed := rooms[:0]
+		for _, room := range rooms {
+			if roomAllowed(roles, room) {
+				allowed = append(allowed, room)
+			}
+		}
+		writeJSON(w, map[string]any{"fp": shortFP(fp), "rooms": allowed})
+	})
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		room := r.URL.Query().Get("room")
+		if room == "" {
+			http.Error(w, "room query parameter is required", http.StatusBadRequest)
+			return
+		}
+		if !roomAllowed(roles, room) {
+			http.Error(w, fmt.Sprintf("not permitted to view room %q", room), http.StatusForbidden)
+			return
+		}
+		n := 50
+		if v := r.URL.Query().Get("n"); v != "" {
+			if parsed, err := strconv.Atoi(v); err == nil {
+				n = parsed
+			}
+		}
+		msgs, err := recentMessages(r.Context(), db, room, n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"fp": shortFP(fp), "room": room, "messages": msgs})
+	})
+	mux.HandleFunc("/presence", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"fp": shortFP(fp), "online": presence.list()})
+	})
+	return mux
+}
+
+// listRooms returns the distinct rooms with at least one posted message,
+// mirroring execListRooms.
+func listRooms(ctx context.Context, db *sql.DB) ([]string, error) {
+	if db == nil {
+		return nil, fmt.Errorf("DATABASE_URL is not configured")
+	}
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT room FROM messages ORDER BY room`)
+	if err != nil {
+		return nil, fmt.Errorf("listing rooms: %w", err)
+	}
+	defer rows.Close()
+
+	var rooms []string
+	for rows.Next() {
+		var room string
+		if err := rows.Scan(&room); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// recentMessages returns the n most recent messages in room, oldest first,
+// mirroring execTail.
+func recentMessages(ctx context.Context, db *sql.DB, room string, n int) ([]execMessage, error) {
+	if db == nil {
+		return nil, fmt.Errorf("DATABASE_URL is not configured")
+	}
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, room, pubkey_fp, body, posted_at FROM messages WHERE room = $1 ORDER BY id DESC LIMIT $2`,
+		room, n,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing messages: %w", err)
+	}
+	defer rows.Close()
+
+	var msgs []execMessage
+	for rows.Next() {
+		var m execMessage
+		if err := rows.Scan(&m.ID, &m.Room, &m.PubkeyFP, &m.Body, &m.PostedAt); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+// presenceRegistry tracks which pubkey fingerprints currently have an
+// active interactive session, for the tunnel API's /presence endpoint.
+// Reference-counted so a user with more than one open session is only
+// dropped once their last session ends.
+type presenceRegistry struct {
+	mu     sync.Mutex
+	online map[string]int
+}
+
+func newPresenceRegistry() *presenceRegistry {
+	return &presenceRegistry{online: map[string]int{}}
+}
+
+func (p *presenceRegistry) join(fp string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.online[fp]++
+}
+
+func (p *presenceRegistry) leave(fp string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.online[fp] <= 1 {
+		delete(p.online, fp)
+		return
+	}
+	p.online[fp]--
+}
+
+func (p *presenceRegistry) list() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]string, 0, len(p.online))
+	for fp := range p.online {
+		out = append(out, shortFP(fp))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// channelConn adapts a gossh.Channel (an io.ReadWriteCloser) to net.Conn so
+// it can back a net/http server as if it were a real TCP socket.
+type channelConn struct {
+	gossh.Channel
+	local net.Addr
+}
+
+// tunnelAddr is a stand-in net.Addr for the tunnel's virtual endpoint.
+type tunnelAddr struct{}
+
+func (tunnelAddr) Network() string { return "direct-tcpip" }
+func (tunnelAddr) String() string  { return apiSentinelHost }
+
+func (c *channelConn) LocalAddr() net.Addr                { return c.local }
+func (c *channelConn) RemoteAddr() net.Addr               { return c.local }
+func (c *channelConn) SetDeadline(t time.Time) error      { return nil }
+func (c *channelConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *channelConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// singleConnListener is a net.Listener that yields exactly one connection,
+// used to run an http.Server over a single SSH channel.
+type singleConnListener struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	var c net.Conn
+	l.once.Do(func() { c = l.conn })
+	if c == nil {
+		<-l.done
+		return nil, io.EOF
+	}
+	return c, nil
+}
+
+func (l *singleConnListener) Close() error {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+func remoteAddr2(conn *gossh.ServerConn) string {
+	if conn == nil || conn.RemoteAddr() == nil {
+		return ""
+	}
+	return conn.RemoteAddr().String()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPresenceRegistryJoinLeave(t *testing.T) {
+	p := newPresenceRegistry()
+	p.join("fp-a")
+	p.join("fp-b")
+	p.join("fp-a") // second session for the same user
+
+	if got, want := p.list(), []string{"fp-a", "fp-b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("list() = %v, want %v", got, want)
+	}
+
+	p.leave("fp-a") // one of fp-a's two sessions ends
+	if got, want := p.list(), []string{"fp-a", "fp-b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("list() after first leave = %v, want %v", got, want)
+	}
+
+	p.leave("fp-a") // fp-a's last session ends
+	if got, want := p.list(), []string{"fp-b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("list() after second leave = %v, want %v", got, want)
+	}
+}
+
+func TestBBSAPIHandlerMessagesEnforcesRoomScope(t *testing.T) {
+	h := bbsAPIHandler("SHA256:test", []string{"room:lobby"}, nil, newPresenceRegistry())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages?room=dev", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("/messages?room=dev status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestBBSAPIHandlerPresenceIgnoresRoomScope(t *testing.T) {
+	presence := newPresenceRegistry()
+	presence.join("SHA256:other")
+	h := bbsAPIHandler("SHA256:test", []string{"room:lobby"}, nil, presence)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/presence", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/presence status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}